@@ -0,0 +1,199 @@
+// Package api holds declarations for types used in ipfs-cluster APIs to make
+// them re-usable across differen transports. This sub-package is used by
+// the cluster, the "restapi" and "ipfsproxy" components and the Go client.
+package api
+
+import (
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// TrackerStatus values
+const (
+	// IPFSStatus should never take this value. When used, it means
+	// an error happened when obtaining the status.
+	TrackerStatusUndefined TrackerStatus = 0
+	// The cluster node is offline or not responding
+	TrackerStatusClusterError TrackerStatus = 1 << iota
+	// An error occurred pinning
+	TrackerStatusPinError
+	// An error occurred unpinning
+	TrackerStatusUnpinError
+	// The IPFS daemon has pinned the item
+	TrackerStatusPinned
+	// The IPFS daemon is currently pinning the item
+	TrackerStatusPinning
+	// The IPFS daemon is currently unpinning the item
+	TrackerStatusUnpinning
+	// The IPFS daemon is not pinning the item
+	TrackerStatusUnpinned
+	// The IPFS daemon is not pinning the item but it is being
+	// tracked
+	TrackerStatusRemote
+	// The item has been queued for pinning on the IPFS daemon
+	TrackerStatusPinQueued
+	// The item has been queued for unpinning on the IPFS daemon
+	TrackerStatusUnpinQueued
+	// The item is looked at and the decision was made to not act on it
+	TrackerStatusNoStatus
+	// The item is in the state of being retried on the IPFS daemon
+	// after having failed once, with a backoff delay between attempts
+	TrackerStatusPinRetrying
+)
+
+// TrackerStatus values
+type TrackerStatus int
+
+// String converts a TrackerStatus into a readable string.
+func (st TrackerStatus) String() string {
+	switch st {
+	case TrackerStatusClusterError:
+		return "cluster_error"
+	case TrackerStatusPinError:
+		return "pin_error"
+	case TrackerStatusUnpinError:
+		return "unpin_error"
+	case TrackerStatusPinned:
+		return "pinned"
+	case TrackerStatusPinning:
+		return "pinning"
+	case TrackerStatusPinQueued:
+		return "pin_queued"
+	case TrackerStatusPinRetrying:
+		return "pin_retrying"
+	case TrackerStatusUnpinning:
+		return "unpinning"
+	case TrackerStatusUnpinQueued:
+		return "unpin_queued"
+	case TrackerStatusUnpinned:
+		return "unpinned"
+	case TrackerStatusRemote:
+		return "remote"
+	default:
+		return ""
+	}
+}
+
+// IPFSPinStatus values
+const (
+	IPFSPinStatusBug IPFSPinStatus = iota
+	IPFSPinStatusError
+	IPFSPinStatusDirect
+	IPFSPinStatusRecursive
+	IPFSPinStatusIndirect
+	IPFSPinStatusUnpinned
+)
+
+// IPFSPinStatus represents the status of a pin in IPFS (direct, recursive etc.)
+type IPFSPinStatus int
+
+// Priority represents the relative importance given to a pin/unpin
+// operation by the PinTracker. Operations with a higher Priority preempt
+// queued (not yet started) operations with a lower one.
+type Priority int
+
+// Priority values. The zero value is PriorityNormal so that pins created
+// without specifying a priority behave as before this field was
+// introduced.
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+// String converts a Priority into a readable string.
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+// Pin carries all the information associated to a CID that is pinned
+// in IPFS Cluster.
+type Pin struct {
+	Cid                  *cid.Cid
+	Allocations          []peer.ID
+	ReplicationFactorMin int
+	ReplicationFactorMax int
+
+	// Priority influences the order in which this pin is handled by a
+	// PinTracker relative to other pins still queued for the same
+	// operation. It defaults to PriorityNormal.
+	Priority Priority
+}
+
+// ToSerial converts a Pin to its serializable version.
+func (pin Pin) ToSerial() PinSerial {
+	return PinSerial{
+		Cid:                  pin.Cid.String(),
+		Allocations:          peersToStrings(pin.Allocations),
+		ReplicationFactorMin: pin.ReplicationFactorMin,
+		ReplicationFactorMax: pin.ReplicationFactorMax,
+		Priority:             int(pin.Priority),
+	}
+}
+
+// PinSerial is the serializable version of a Pin.
+type PinSerial struct {
+	Cid                  string   `json:"cid"`
+	Allocations          []string `json:"allocations"`
+	ReplicationFactorMin int      `json:"replication_factor_min"`
+	ReplicationFactorMax int      `json:"replication_factor_max"`
+	Priority             int      `json:"priority,omitempty"`
+}
+
+// ToPin converts a PinSerial to its native version.
+func (pins PinSerial) ToPin() Pin {
+	c, _ := cid.Decode(pins.Cid)
+	return Pin{
+		Cid:                  c,
+		Allocations:          stringsToPeers(pins.Allocations),
+		ReplicationFactorMin: pins.ReplicationFactorMin,
+		ReplicationFactorMax: pins.ReplicationFactorMax,
+		Priority:             Priority(pins.Priority),
+	}
+}
+
+func peersToStrings(peers []peer.ID) []string {
+	strs := make([]string, len(peers))
+	for i, p := range peers {
+		strs[i] = p.Pretty()
+	}
+	return strs
+}
+
+func stringsToPeers(strs []string) []peer.ID {
+	peers := make([]peer.ID, 0, len(strs))
+	for _, s := range strs {
+		p, err := peer.IDB58Decode(s)
+		if err == nil {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// PinInfo holds information about local pins.
+type PinInfo struct {
+	Cid    *cid.Cid
+	Peer   peer.ID
+	Status TrackerStatus
+	TS     time.Time
+	Error  string
+
+	// AttemptCount tracks how many times a PinTracker has attempted to
+	// perform the current pin/unpin operation, including the initial
+	// one. It is reset whenever a fresh Track/Untrack request comes in.
+	AttemptCount int
+	// NextRetry is the time at which a tracker with status
+	// TrackerStatusPinRetrying will next attempt the operation. It is
+	// the zero time when no retry is scheduled.
+	NextRetry time.Time
+}
@@ -0,0 +1,405 @@
+// Package optracker implements functionality to track the status of
+// pin and unpin operations as used by the stateless PinTracker, as well
+// as to cancel ongoing operations.
+package optracker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+var logger = logging.Logger("optracker")
+
+// OperationType represents the kinds of operations that the PinTracker
+// performs and the OperationTracker keeps track of.
+type OperationType int
+
+// String returns a human-readable representation of an OperationType.
+func (op OperationType) String() string {
+	switch op {
+	case OperationPin:
+		return "pin"
+	case OperationUnpin:
+		return "unpin"
+	default:
+		return "unknown"
+	}
+}
+
+// The type of operations that the PinTracker can carry out.
+const (
+	OperationPin OperationType = iota
+	OperationUnpin
+)
+
+// Phase represents in which stage an operation is as tracked by the
+// OperationTracker.
+type Phase int
+
+// String returns a human-readable representation of a Phase.
+func (p Phase) String() string {
+	switch p {
+	case PhaseQueued:
+		return "queued"
+	case PhaseInProgress:
+		return "in progress"
+	case PhaseDone:
+		return "done"
+	case PhaseError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// The possible phases an operation can be in.
+const (
+	PhaseQueued Phase = iota
+	PhaseInProgress
+	PhaseDone
+	PhaseError
+)
+
+// Operation represents a pin or unpin operation and its status as it
+// goes through the tracker's queue and worker pool.
+type Operation struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.RWMutex
+	cid      *cid.Cid
+	opType   OperationType
+	phase    Phase
+	priority api.Priority
+	// seq is a monotonic tiebreaker used to keep FIFO order between
+	// operations sharing the same priority.
+	seq   uint64
+	ts    time.Time
+	error string
+
+	attemptCount int
+	nextRetry    time.Time
+}
+
+// Cid returns the Cid associated to this operation.
+func (op *Operation) Cid() *cid.Cid {
+	return op.cid
+}
+
+// Type returns the operation's type.
+func (op *Operation) Type() OperationType {
+	op.mu.RLock()
+	defer op.mu.RUnlock()
+	return op.opType
+}
+
+// Phase returns the operation's phase.
+func (op *Operation) Phase() Phase {
+	op.mu.RLock()
+	defer op.mu.RUnlock()
+	return op.phase
+}
+
+// SetPhase changes the phase of an operation and bumps its timestamp. Any
+// previously recorded error is cleared unless the new phase is itself
+// PhaseError.
+func (op *Operation) SetPhase(p Phase) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.phase = p
+	op.ts = time.Now()
+	if p != PhaseError {
+		op.error = ""
+	}
+}
+
+// Priority returns the operation's priority.
+func (op *Operation) Priority() api.Priority {
+	op.mu.RLock()
+	defer op.mu.RUnlock()
+	return op.priority
+}
+
+// Seq returns the monotonically increasing sequence number assigned to
+// this operation when it was created. It is used to break ties between
+// operations sharing the same Priority so that they are handled in
+// FIFO order.
+func (op *Operation) Seq() uint64 {
+	return op.seq
+}
+
+// Context returns the context associated with this operation.
+func (op *Operation) Context() context.Context {
+	return op.ctx
+}
+
+// Cancel stops the operation, whether queued or in progress.
+func (op *Operation) Cancel() {
+	op.cancel()
+}
+
+// AttemptCount returns how many times this operation has been attempted,
+// including the initial try.
+func (op *Operation) AttemptCount() int {
+	op.mu.RLock()
+	defer op.mu.RUnlock()
+	return op.attemptCount
+}
+
+// IncAttempt increases the attempt counter for this operation.
+func (op *Operation) IncAttempt() {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.attemptCount++
+}
+
+// SetNextRetry records when the next retry of this operation is due.
+func (op *Operation) SetNextRetry(t time.Time) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.nextRetry = t
+}
+
+// NextRetry returns the time at which this operation will be retried, or
+// the zero time if no retry is scheduled.
+func (op *Operation) NextRetry() time.Time {
+	op.mu.RLock()
+	defer op.mu.RUnlock()
+	return op.nextRetry
+}
+
+// SetError marks the operation as errored.
+func (op *Operation) SetError(err error) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.phase = PhaseError
+	op.error = err.Error()
+	op.ts = time.Now()
+}
+
+// Error returns the last error recorded for this operation, if any.
+func (op *Operation) Error() string {
+	op.mu.RLock()
+	defer op.mu.RUnlock()
+	return op.error
+}
+
+// ToTrackerStatus returns the TrackerStatus equivalent of this operation's
+// type and phase, used when reporting PinInfo to the rest of the system.
+func (op *Operation) ToTrackerStatus() api.TrackerStatus {
+	op.mu.RLock()
+	defer op.mu.RUnlock()
+	return trackerStatus(op.opType, op.phase)
+}
+
+// trackerStatus maps an operation's type and phase to the TrackerStatus
+// reported for it. It takes no lock, so callers that already hold
+// op.mu can use it directly instead of going through ToTrackerStatus,
+// which would otherwise re-acquire op.mu and could deadlock behind a
+// writer queued in between the two RLock calls.
+func trackerStatus(opType OperationType, phase Phase) api.TrackerStatus {
+	switch opType {
+	case OperationPin:
+		switch phase {
+		case PhaseQueued:
+			return api.TrackerStatusPinQueued
+		case PhaseInProgress:
+			return api.TrackerStatusPinning
+		case PhaseDone:
+			return api.TrackerStatusPinned
+		case PhaseError:
+			return api.TrackerStatusPinError
+		default:
+			return api.TrackerStatusUndefined
+		}
+	case OperationUnpin:
+		switch phase {
+		case PhaseQueued:
+			return api.TrackerStatusUnpinQueued
+		case PhaseInProgress:
+			return api.TrackerStatusUnpinning
+		case PhaseDone:
+			return api.TrackerStatusUnpinned
+		case PhaseError:
+			return api.TrackerStatusUnpinError
+		default:
+			return api.TrackerStatusUndefined
+		}
+	default:
+		return api.TrackerStatusUndefined
+	}
+}
+
+// OperationTracker tracks and manages all the in-flight and queued
+// operations of a PinTracker, as well as providing the ability to cancel
+// them.
+type OperationTracker struct {
+	mu         sync.RWMutex
+	operations map[string]*Operation
+	seq        uint64
+}
+
+// NewOperationTracker creates a new, empty OperationTracker.
+func NewOperationTracker() *OperationTracker {
+	return &OperationTracker{
+		operations: make(map[string]*Operation),
+	}
+}
+
+// TrackNewOperation creates, registers and returns a new Operation for
+// the given Cid, replacing (and cancelling) any previous operation
+// tracked for the same Cid.
+func (opt *OperationTracker) TrackNewOperation(ctx context.Context, c *cid.Cid, typ OperationType, priority api.Priority) *Operation {
+	opt.mu.Lock()
+	defer opt.mu.Unlock()
+
+	if prev, ok := opt.operations[c.String()]; ok {
+		prev.Cancel()
+	}
+
+	opCtx, cancel := context.WithCancel(ctx)
+	opt.seq++
+	op := &Operation{
+		ctx:      opCtx,
+		cancel:   cancel,
+		cid:      c,
+		opType:   typ,
+		phase:    PhaseQueued,
+		priority: priority,
+		seq:      opt.seq,
+		ts:       time.Now(),
+	}
+	opt.operations[c.String()] = op
+	return op
+}
+
+// Clean removes an operation from the tracker if it is still the one
+// referenced (i.e. it has not already been replaced by a newer one).
+func (opt *OperationTracker) Clean(op *Operation) {
+	opt.mu.Lock()
+	defer opt.mu.Unlock()
+	if cur, ok := opt.operations[op.cid.String()]; ok && cur == op {
+		delete(opt.operations, op.cid.String())
+	}
+}
+
+// CancelAll cancels every operation currently tracked, whether it is
+// queued, in progress or waiting for a retry.
+func (opt *OperationTracker) CancelAll() {
+	opt.mu.RLock()
+	defer opt.mu.RUnlock()
+	for _, op := range opt.operations {
+		op.Cancel()
+	}
+}
+
+// Get returns the PinInfo representing the status of the operation
+// tracked for the given Cid. If there is no such operation, a PinInfo
+// with status TrackerStatusUnpinned and a nil Cid is returned.
+func (opt *OperationTracker) Get(c *cid.Cid) api.PinInfo {
+	opt.mu.RLock()
+	op, ok := opt.operations[c.String()]
+	opt.mu.RUnlock()
+	if !ok {
+		return api.PinInfo{
+			Cid:    nil,
+			Status: api.TrackerStatusUnpinned,
+			TS:     time.Now(),
+		}
+	}
+	return pinInfo(op)
+}
+
+// pinInfo builds the PinInfo for an already-resolved operation. It does
+// not look the operation back up in the tracker's map, so it is safe to
+// call on an *Operation snapshotted earlier, even if the operation has
+// since been Clean()-ed.
+func pinInfo(op *Operation) api.PinInfo {
+	op.mu.RLock()
+	defer op.mu.RUnlock()
+	status := trackerStatus(op.opType, op.phase)
+	if !op.nextRetry.IsZero() && op.phase == PhaseError {
+		status = api.TrackerStatusPinRetrying
+	}
+	return api.PinInfo{
+		Cid:          op.cid,
+		Status:       status,
+		TS:           op.ts,
+		Error:        op.error,
+		AttemptCount: op.attemptCount,
+		NextRetry:    op.nextRetry,
+	}
+}
+
+// GetAll returns PinInfo for every operation currently tracked.
+func (opt *OperationTracker) GetAll() []api.PinInfo {
+	opt.mu.RLock()
+	ops := make([]*Operation, 0, len(opt.operations))
+	for _, op := range opt.operations {
+		ops = append(ops, op)
+	}
+	opt.mu.RUnlock()
+
+	pinfos := make([]api.PinInfo, len(ops))
+	for i, op := range ops {
+		pinfos[i] = pinInfo(op)
+	}
+	return pinfos
+}
+
+// OpContext returns the context of the operation tracked for the given
+// Cid, or nil if there is none.
+func (opt *OperationTracker) OpContext(c *cid.Cid) context.Context {
+	opt.mu.RLock()
+	defer opt.mu.RUnlock()
+	op, ok := opt.operations[c.String()]
+	if !ok {
+		return nil
+	}
+	return op.ctx
+}
+
+// SetError marks the tracked operation for the given Cid as errored and
+// returns its resulting PinInfo. The PinInfo is built from the same
+// operation that was just mutated, rather than looking the Cid back up
+// in the tracker's map, so callers get a consistent result even if the
+// operation is concurrently Clean()-ed right afterwards.
+func (opt *OperationTracker) SetError(c *cid.Cid, err error) api.PinInfo {
+	opt.mu.RLock()
+	op, ok := opt.operations[c.String()]
+	opt.mu.RUnlock()
+	if !ok {
+		return api.PinInfo{
+			Cid:    nil,
+			Status: api.TrackerStatusUnpinned,
+			TS:     time.Now(),
+		}
+	}
+	op.SetError(err)
+	return pinInfo(op)
+}
+
+// SetPhase changes the phase of the operation tracked for the given Cid,
+// if any is currently tracked, and returns its resulting PinInfo. As
+// with SetError, the PinInfo is built from the same operation that was
+// just mutated rather than looked up again afterwards.
+func (opt *OperationTracker) SetPhase(c *cid.Cid, p Phase) api.PinInfo {
+	opt.mu.RLock()
+	op, ok := opt.operations[c.String()]
+	opt.mu.RUnlock()
+	if !ok {
+		return api.PinInfo{
+			Cid:    nil,
+			Status: api.TrackerStatusUnpinned,
+			TS:     time.Now(),
+		}
+	}
+	op.SetPhase(p)
+	return pinInfo(op)
+}
@@ -0,0 +1,150 @@
+package stateless
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const configKey = "stateless"
+
+// Default values for Config.
+const (
+	// DefaultMaxConcurrentPins is the maximum number of pin or unpin
+	// operations that a Tracker runs at the same time by default. It
+	// matches the previous, implicit behavior of the tracker, which
+	// only ever pinned or unpinned one item at a time.
+	DefaultMaxConcurrentPins = 1
+
+	// DefaultPinRetryInitialDelay is how long a Tracker waits, by
+	// default, before the first automatic retry of a failed pin/unpin
+	// operation.
+	DefaultPinRetryInitialDelay = 5 * time.Second
+	// DefaultPinRetryMultiplier is the default factor by which the
+	// retry delay grows after every failed attempt.
+	DefaultPinRetryMultiplier = 2.0
+	// DefaultPinRetryMaxDelay caps the default delay between retries.
+	DefaultPinRetryMaxDelay = time.Minute
+	// DefaultPinRetryMaxAttempts is the default number of attempts,
+	// including the initial one, that a Tracker makes at a failing
+	// pin/unpin operation before giving up.
+	DefaultPinRetryMaxAttempts = 5
+)
+
+// Config allows to initialize a Tracker and customize some parameters.
+type Config struct {
+	// MaxConcurrentPins is the maximum number of pin or unpin
+	// operations that the tracker will run at the same time. Queued
+	// operations wait for a free slot, and a higher-priority pin
+	// preempts a lower-priority one that is still queued (but never
+	// one that has already started). A value of 0 means no limit;
+	// negative values are rejected by Validate.
+	MaxConcurrentPins int
+
+	// PinRetryInitialDelay is how long the tracker waits before the
+	// first automatic retry of a failed pin/unpin operation.
+	PinRetryInitialDelay time.Duration
+	// PinRetryMultiplier is the factor by which the retry delay grows
+	// after every failed attempt.
+	PinRetryMultiplier float64
+	// PinRetryMaxDelay caps the delay between retries.
+	PinRetryMaxDelay time.Duration
+	// PinRetryMaxAttempts is how many times the tracker retries a
+	// failed pin/unpin operation, including the initial try, before
+	// giving up. A value of 0 or less disables retries.
+	PinRetryMaxAttempts int
+}
+
+// ConfigJSON represents a Config as it will look when it is saved using
+// JSON. Key names aim to be self-explanatory for the user.
+type ConfigJSON struct {
+	MaxConcurrentPins    int     `json:"max_concurrent_pins"`
+	PinRetryInitialDelay string  `json:"pin_retry_initial_delay"`
+	PinRetryMultiplier   float64 `json:"pin_retry_multiplier"`
+	PinRetryMaxDelay     string  `json:"pin_retry_max_delay"`
+	PinRetryMaxAttempts  *int    `json:"pin_retry_max_attempts"`
+}
+
+// ConfigKey returns a human-friendly indentifier for this Config.
+func (cfg *Config) ConfigKey() string {
+	return configKey
+}
+
+// Default initializes this configuration with default values.
+func (cfg *Config) Default() error {
+	cfg.MaxConcurrentPins = DefaultMaxConcurrentPins
+	cfg.PinRetryInitialDelay = DefaultPinRetryInitialDelay
+	cfg.PinRetryMultiplier = DefaultPinRetryMultiplier
+	cfg.PinRetryMaxDelay = DefaultPinRetryMaxDelay
+	cfg.PinRetryMaxAttempts = DefaultPinRetryMaxAttempts
+	return nil
+}
+
+// Validate checks that the fields of this Config have working values,
+// at least in appearance.
+func (cfg *Config) Validate() error {
+	if cfg.MaxConcurrentPins < 0 {
+		return errors.New("stateless.max_concurrent_pins is invalid")
+	}
+	if cfg.PinRetryMaxAttempts > 0 {
+		if cfg.PinRetryInitialDelay <= 0 {
+			return errors.New("stateless.pin_retry_initial_delay must be set when pin_retry_max_attempts > 0")
+		}
+		if cfg.PinRetryMultiplier < 1 {
+			return errors.New("stateless.pin_retry_multiplier must be >= 1")
+		}
+		if cfg.PinRetryMaxDelay < cfg.PinRetryInitialDelay {
+			return errors.New("stateless.pin_retry_max_delay must be >= pin_retry_initial_delay")
+		}
+	}
+	return nil
+}
+
+// LoadJSON parses a raw JSON byte-slice as generated by ToJSON() and
+// sets the Config fields accordingly.
+func (cfg *Config) LoadJSON(raw []byte) error {
+	jcfg := &ConfigJSON{}
+	err := json.Unmarshal(raw, jcfg)
+	if err != nil {
+		return err
+	}
+
+	cfg.Default()
+
+	cfg.MaxConcurrentPins = jcfg.MaxConcurrentPins
+	if jcfg.PinRetryInitialDelay != "" {
+		d, err := time.ParseDuration(jcfg.PinRetryInitialDelay)
+		if err != nil {
+			return err
+		}
+		cfg.PinRetryInitialDelay = d
+	}
+	if jcfg.PinRetryMultiplier != 0 {
+		cfg.PinRetryMultiplier = jcfg.PinRetryMultiplier
+	}
+	if jcfg.PinRetryMaxDelay != "" {
+		d, err := time.ParseDuration(jcfg.PinRetryMaxDelay)
+		if err != nil {
+			return err
+		}
+		cfg.PinRetryMaxDelay = d
+	}
+	if jcfg.PinRetryMaxAttempts != nil {
+		cfg.PinRetryMaxAttempts = *jcfg.PinRetryMaxAttempts
+	}
+
+	return cfg.Validate()
+}
+
+// ToJSON generates a human-friendly JSON representation of this Config.
+func (cfg *Config) ToJSON() ([]byte, error) {
+	maxAttempts := cfg.PinRetryMaxAttempts
+	jcfg := &ConfigJSON{
+		MaxConcurrentPins:    cfg.MaxConcurrentPins,
+		PinRetryInitialDelay: cfg.PinRetryInitialDelay.String(),
+		PinRetryMultiplier:   cfg.PinRetryMultiplier,
+		PinRetryMaxDelay:     cfg.PinRetryMaxDelay.String(),
+		PinRetryMaxAttempts:  &maxAttempts,
+	}
+	return json.MarshalIndent(jcfg, "", "    ")
+}
@@ -0,0 +1,160 @@
+package stateless
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+// orderedMockService is a minimal IPFS mock that records the order in
+// which IPFSPin calls arrive and can optionally block a given Cid on a
+// channel, so that tests can deterministically observe dispatch order.
+type orderedMockService struct {
+	mu      sync.Mutex
+	started []string
+	block   map[string]chan struct{}
+}
+
+func (m *orderedMockService) IPFSPin(ctx context.Context, in api.PinSerial, out *struct{}) error {
+	c := in.ToPin().Cid.String()
+
+	m.mu.Lock()
+	m.started = append(m.started, c)
+	ch := m.block[c]
+	m.mu.Unlock()
+
+	if ch != nil {
+		<-ch
+	}
+	return nil
+}
+
+// TestTrack_PriorityPreemption checks that a high priority pin queued
+// behind a low priority one is nonetheless dispatched first, as soon as
+// a worker slot frees up.
+func TestTrack_PriorityPreemption(t *testing.T) {
+	cfg := &Config{}
+	cfg.Default() // MaxConcurrentPins == 1: only one pin runs at a time
+
+	slowCid := test.MustDecodeCid(test.TestSlowCid1)
+	lowCid := test.MustDecodeCid(test.TestCid1)
+	highCid := test.MustDecodeCid(test.TestCid2)
+
+	mock := &orderedMockService{
+		block: map[string]chan struct{}{
+			slowCid.String(): make(chan struct{}),
+		},
+	}
+
+	spt := New(cfg, test.TestPeerID1)
+	spt.SetClient(newMockRPCClient(t, mock))
+	defer spt.Shutdown()
+
+	err := spt.Track(api.Pin{Cid: slowCid, ReplicationFactorMax: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // let slowCid take the only worker slot
+
+	low := api.Pin{Cid: lowCid, ReplicationFactorMax: -1, Priority: api.PriorityLow}
+	high := api.Pin{Cid: highCid, ReplicationFactorMax: -1, Priority: api.PriorityHigh}
+
+	if err := spt.Track(low); err != nil {
+		t.Fatal(err)
+	}
+	if err := spt.Track(high); err != nil {
+		t.Fatal(err)
+	}
+
+	if pi := spt.optracker.Get(lowCid); pi.Status != api.TrackerStatusPinQueued {
+		t.Fatalf("low priority pin should be queued behind the slow pin, got %s", pi.Status)
+	}
+	if pi := spt.optracker.Get(highCid); pi.Status != api.TrackerStatusPinQueued {
+		t.Fatalf("high priority pin should be queued behind the slow pin, got %s", pi.Status)
+	}
+
+	close(mock.block[slowCid.String()]) // let the slow pin finish, freeing the worker slot
+	time.Sleep(100 * time.Millisecond)
+
+	mock.mu.Lock()
+	started := append([]string{}, mock.started...)
+	mock.mu.Unlock()
+
+	indexOf := func(c string) int {
+		for i, s := range started {
+			if s == c {
+				return i
+			}
+		}
+		return -1
+	}
+
+	hi, lo := indexOf(highCid.String()), indexOf(lowCid.String())
+	if hi == -1 {
+		t.Fatal("high priority pin never started")
+	}
+	if lo != -1 && lo < hi {
+		t.Fatalf("low priority pin started (position %d) before the high priority one (position %d)", lo, hi)
+	}
+}
+
+// concurrencyMockService is a minimal IPFS mock whose IPFSPin blocks
+// until released, tracking how many calls are in flight at once.
+type concurrencyMockService struct {
+	running int32
+	max     int32
+	release chan struct{}
+}
+
+func (m *concurrencyMockService) IPFSPin(ctx context.Context, in api.PinSerial, out *struct{}) error {
+	cur := atomic.AddInt32(&m.running, 1)
+	defer atomic.AddInt32(&m.running, -1)
+
+	for {
+		old := atomic.LoadInt32(&m.max)
+		if cur <= old || atomic.CompareAndSwapInt32(&m.max, old, cur) {
+			break
+		}
+	}
+
+	<-m.release
+	return nil
+}
+
+// TestTrack_ConcurrencyCap checks that the tracker never runs more pins
+// at the same time than Config.MaxConcurrentPins allows.
+func TestTrack_ConcurrencyCap(t *testing.T) {
+	cfg := &Config{}
+	cfg.Default()
+	cfg.MaxConcurrentPins = 2
+
+	mock := &concurrencyMockService{release: make(chan struct{})}
+
+	spt := New(cfg, test.TestPeerID1)
+	spt.SetClient(newMockRPCClient(t, mock))
+	defer spt.Shutdown()
+
+	cids := []string{test.TestCid1, test.TestCid2, test.TestCid3, test.TestSlowCid1}
+	for _, c := range cids {
+		pin := api.Pin{Cid: test.MustDecodeCid(c), ReplicationFactorMax: -1}
+		if err := spt.Track(pin); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(200 * time.Millisecond) // let the dispatcher fill up its worker slots
+
+	if got := atomic.LoadInt32(&mock.running); got != int32(cfg.MaxConcurrentPins) {
+		t.Errorf("expected exactly %d pins running concurrently, got %d", cfg.MaxConcurrentPins, got)
+	}
+	if got := atomic.LoadInt32(&mock.max); got > int32(cfg.MaxConcurrentPins) {
+		t.Errorf("concurrency cap exceeded: saw %d pins running at once, max allowed %d", got, cfg.MaxConcurrentPins)
+	}
+
+	close(mock.release)
+}
@@ -0,0 +1,140 @@
+package stateless
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+// flakyMockService fails the first failBefore calls to IPFSPin and
+// succeeds afterwards, simulating a transient ipfs daemon error.
+// IPFSUnpin always succeeds.
+type flakyMockService struct {
+	failBefore int32
+	calls      int32
+}
+
+func (m *flakyMockService) IPFSPin(ctx context.Context, in api.PinSerial, out *struct{}) error {
+	if atomic.AddInt32(&m.calls, 1) <= m.failBefore {
+		return errors.New("transient ipfs error")
+	}
+	return nil
+}
+
+func (m *flakyMockService) IPFSUnpin(ctx context.Context, in api.PinSerial, out *struct{}) error {
+	return nil
+}
+
+// TestTrack_RetryEventuallySucceeds checks that a pin which fails a few
+// times in a row is retried until it succeeds, within the configured
+// retry budget.
+func TestTrack_RetryEventuallySucceeds(t *testing.T) {
+	cfg := &Config{}
+	cfg.Default()
+	cfg.PinRetryInitialDelay = 10 * time.Millisecond
+	cfg.PinRetryMultiplier = 1
+	cfg.PinRetryMaxDelay = 50 * time.Millisecond
+	cfg.PinRetryMaxAttempts = 5
+
+	mock := &flakyMockService{failBefore: 3}
+	spt := New(cfg, test.TestPeerID1)
+	spt.SetClient(newMockRPCClient(t, mock))
+	defer spt.Shutdown()
+
+	c := test.MustDecodeCid(test.TestCid1)
+	if err := spt.Track(api.Pin{Cid: c, ReplicationFactorMax: -1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A successful pin is cleaned up from the tracker right away (as
+	// with any other completed operation, see Tracker.run),
+	// so success is observed through the mock having been called
+	// exactly once more than the number of injected failures, and no
+	// further retries being attempted afterwards.
+	wantCalls := mock.failBefore + 1
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && atomic.LoadInt32(&mock.calls) < wantCalls {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond) // give a stray extra retry a chance to happen
+	if got := atomic.LoadInt32(&mock.calls); got != wantCalls {
+		t.Fatalf("expected exactly %d IPFSPin calls (retries stopping once the pin succeeds), got %d", wantCalls, got)
+	}
+	if pi := spt.optracker.Get(c); pi.Status == api.TrackerStatusPinError || pi.Status == api.TrackerStatusPinRetrying {
+		t.Fatalf("pin should have succeeded, got status %s", pi.Status)
+	}
+}
+
+// TestTrack_RetryExhaustsAttempts checks that a pin which never succeeds
+// gives up after Config.PinRetryMaxAttempts tries and is left in
+// TrackerStatusPinError.
+func TestTrack_RetryExhaustsAttempts(t *testing.T) {
+	cfg := &Config{}
+	cfg.Default()
+	cfg.PinRetryInitialDelay = 5 * time.Millisecond
+	cfg.PinRetryMultiplier = 1
+	cfg.PinRetryMaxDelay = 20 * time.Millisecond
+	cfg.PinRetryMaxAttempts = 2
+
+	mock := &flakyMockService{failBefore: 1000} // always fails
+	spt := New(cfg, test.TestPeerID1)
+	spt.SetClient(newMockRPCClient(t, mock))
+	defer spt.Shutdown()
+
+	c := test.MustDecodeCid(test.TestCid1)
+	if err := spt.Track(api.Pin{Cid: c, ReplicationFactorMax: -1}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	pi := spt.optracker.Get(c)
+	if pi.Status != api.TrackerStatusPinError {
+		t.Fatalf("expected the pin to give up as pin_error after %d attempts, got %s", cfg.PinRetryMaxAttempts, pi.Status)
+	}
+	if pi.AttemptCount != cfg.PinRetryMaxAttempts {
+		t.Fatalf("expected %d attempts, got %d", cfg.PinRetryMaxAttempts, pi.AttemptCount)
+	}
+}
+
+// TestUntrack_CancelsPendingRetry checks that Untrack-ing a pin that is
+// waiting for its next retry stops that retry from ever running.
+func TestUntrack_CancelsPendingRetry(t *testing.T) {
+	cfg := &Config{}
+	cfg.Default()
+	cfg.PinRetryInitialDelay = 300 * time.Millisecond
+	cfg.PinRetryMultiplier = 1
+	cfg.PinRetryMaxDelay = 300 * time.Millisecond
+	cfg.PinRetryMaxAttempts = 5
+
+	mock := &flakyMockService{failBefore: 1000} // always fails
+	spt := New(cfg, test.TestPeerID1)
+	spt.SetClient(newMockRPCClient(t, mock))
+	defer spt.Shutdown()
+
+	c := test.MustDecodeCid(test.TestCid1)
+	if err := spt.Track(api.Pin{Cid: c, ReplicationFactorMax: -1}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond) // let the first (failing) attempt schedule a retry
+	if pi := spt.optracker.Get(c); pi.Status != api.TrackerStatusPinRetrying {
+		t.Fatalf("expected the pin to be waiting for a retry, got %s", pi.Status)
+	}
+
+	if err := spt.Untrack(c); err != nil {
+		t.Fatal(err)
+	}
+
+	callsAfterUntrack := atomic.LoadInt32(&mock.calls)
+	time.Sleep(500 * time.Millisecond) // well past when the cancelled retry would have fired
+	if got := atomic.LoadInt32(&mock.calls); got != callsAfterUntrack {
+		t.Fatalf("a cancelled retry still ran: %d IPFSPin calls before Untrack settled, %d after waiting", callsAfterUntrack, got)
+	}
+}
@@ -0,0 +1,348 @@
+// Package stateless implements a PinTracker component for IPFS Cluster,
+// which aims to reduce the memory footprint when handling really large
+// cluster states by not tracking the state of each pin in-memory, but
+// relying on IPFS and the shared state to verify it on the fly.
+package stateless
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	rpc "github.com/hsanjuan/go-libp2p-gorpc"
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/pintracker/optracker"
+)
+
+var logger = logging.Logger("pintracker")
+
+// Tracker uses the IPFS pinset (plus the global state) as a way to
+// determine which items this peer is pinning. It implements the
+// PinTracker interface by queuing pin/unpin requests and running them
+// against the ipfs daemon through as many workers as configured, honoring
+// the relative Priority of each request.
+type Tracker struct {
+	config *Config
+
+	peerID    peer.ID
+	rpcClient *rpc.Client
+
+	optracker *optracker.OperationTracker
+
+	mu      sync.Mutex
+	queue   opQueue
+	running int
+
+	shutdownOnce sync.Once
+	shutdown     bool
+	wg           sync.WaitGroup
+}
+
+// New creates a new Tracker.
+func New(cfg *Config, pid peer.ID) *Tracker {
+	return &Tracker{
+		config:    cfg,
+		peerID:    pid,
+		optracker: optracker.NewOperationTracker(),
+	}
+}
+
+// SetClient makes the Tracker ready to perform RPC requests to other
+// components.
+func (spt *Tracker) SetClient(c *rpc.Client) {
+	spt.rpcClient = c
+}
+
+// Shutdown finishes the services provided by the Tracker and cancels
+// any queued, in-progress or retry-pending operation.
+func (spt *Tracker) Shutdown() error {
+	spt.shutdownOnce.Do(func() {
+		spt.mu.Lock()
+		spt.shutdown = true
+		spt.queue = nil
+		spt.mu.Unlock()
+		spt.optracker.CancelAll()
+	})
+	spt.wg.Wait()
+	return nil
+}
+
+// Track tells the Tracker to start managing a Cid, pinning it in IPFS
+// when a worker slot becomes available. Pins with a higher Priority are
+// dispatched before queued pins with a lower one.
+func (spt *Tracker) Track(c api.Pin) error {
+	op := spt.optracker.TrackNewOperation(context.Background(), c.Cid, optracker.OperationPin, c.Priority)
+	spt.enqueue(op)
+	return nil
+}
+
+// Untrack tells the Tracker to stop managing a Cid. If the item is
+// currently being pinned, the pinning operation is cancelled right away
+// and an unpin operation is queued in its place.
+func (spt *Tracker) Untrack(c *cid.Cid) error {
+	op := spt.optracker.TrackNewOperation(context.Background(), c, optracker.OperationUnpin, api.PriorityNormal)
+	spt.enqueue(op)
+	return nil
+}
+
+// SyncAll verifies the status of all the locally tracked items which have
+// recorded an error against the ipfs daemon and, for those which ipfs
+// reports as already in the desired state, clears the error. It returns
+// the resulting PinInfo for every item it looked at.
+func (spt *Tracker) SyncAll() ([]api.PinInfo, error) {
+	var synced []api.PinInfo
+	for _, pinfo := range spt.optracker.GetAll() {
+		if pinfo.Status != api.TrackerStatusPinError && pinfo.Status != api.TrackerStatusUnpinError {
+			continue
+		}
+		newPinfo, err := spt.sync(pinfo.Cid, pinfo.Status)
+		if err != nil {
+			return nil, err
+		}
+		synced = append(synced, newPinfo)
+	}
+	return synced, nil
+}
+
+func (spt *Tracker) sync(c *cid.Cid, status api.TrackerStatus) (api.PinInfo, error) {
+	var ips api.IPFSPinStatus
+	err := spt.rpcClient.Call("", "Cluster", "IPFSPinLsCid", api.Pin{Cid: c}.ToSerial(), &ips)
+	if err != nil {
+		spt.optracker.SetError(c, err)
+		return spt.optracker.Get(c), nil
+	}
+
+	confirmed := false
+	switch status {
+	case api.TrackerStatusPinError:
+		confirmed = ips != api.IPFSPinStatusUnpinned
+	case api.TrackerStatusUnpinError:
+		confirmed = ips == api.IPFSPinStatusUnpinned
+	}
+	if confirmed {
+		spt.optracker.SetPhase(c, optracker.PhaseDone)
+	}
+	return spt.optracker.Get(c), nil
+}
+
+// enqueue adds an operation to the priority queue and attempts to
+// dispatch as many queued operations as the concurrency cap allows.
+func (spt *Tracker) enqueue(op *optracker.Operation) {
+	spt.mu.Lock()
+	if spt.shutdown {
+		spt.mu.Unlock()
+		op.Cancel()
+		return
+	}
+	heap.Push(&spt.queue, op)
+	spt.mu.Unlock()
+	spt.dispatch()
+}
+
+// dispatch starts as many queued operations as the configured
+// concurrency cap (spt.config.MaxConcurrentPins) allows. A pin with a
+// higher Priority is always popped off the queue before a lower
+// priority one, regardless of arrival order, so it effectively
+// preempts any queued (not yet started) lower priority pin.
+func (spt *Tracker) dispatch() {
+	max := spt.config.MaxConcurrentPins
+
+	for {
+		spt.mu.Lock()
+		if spt.shutdown || len(spt.queue) == 0 || (max > 0 && spt.running >= max) {
+			spt.mu.Unlock()
+			return
+		}
+		op := heap.Pop(&spt.queue).(*optracker.Operation)
+		spt.running++
+		spt.mu.Unlock()
+
+		op.SetPhase(optracker.PhaseInProgress)
+
+		// release gives back this operation's worker slot and is
+		// guaranteed to run exactly once: either when the ipfs call
+		// returns, or as soon as the operation gets cancelled
+		// (Untrack/a replacing Track), whichever happens first. This
+		// matters because the ipfs daemon request behind a cancelled
+		// operation may keep running in the background for a while,
+		// and we do not want that to hold up the worker slot.
+		var once sync.Once
+		release := func() {
+			once.Do(func() {
+				spt.mu.Lock()
+				spt.running--
+				spt.mu.Unlock()
+				spt.dispatch()
+			})
+		}
+
+		spt.wg.Add(1)
+		go spt.run(op, release)
+		go func() {
+			<-op.Context().Done()
+			release()
+		}()
+	}
+}
+
+// run executes a single pin or unpin operation against IPFS. On failure,
+// it schedules an automatic retry with exponential backoff as long as
+// the configured retry budget (Config.PinRetryMaxAttempts) is not
+// exhausted; the operation is reported as TrackerStatusPinRetrying (see
+// optracker.Get) until the retry runs or the operation is cancelled. Once
+// the budget is exhausted, the operation stays tracked as errored rather
+// than being cleaned up right away, so it can still be reported and
+// reconciled through SyncAll.
+func (spt *Tracker) run(op *optracker.Operation, release func()) {
+	defer spt.wg.Done()
+	defer release()
+
+	if op.Context().Err() != nil { // cancelled while queued
+		op.Cancel()
+		spt.optracker.Clean(op)
+		return
+	}
+
+	op.IncAttempt()
+
+	var err error
+	switch op.Type() {
+	case optracker.OperationPin:
+		err = spt.pin(op)
+	case optracker.OperationUnpin:
+		err = spt.unpin(op)
+	}
+
+	if err != nil {
+		if op.Context().Err() != nil { // cancelled mid-flight
+			op.Cancel()
+			spt.optracker.Clean(op)
+			return
+		}
+		if spt.scheduleRetry(op, err) {
+			return
+		}
+		// The retry budget is exhausted (or retries are disabled): the
+		// operation is left tracked as errored, with its final
+		// AttemptCount and Error, so that SyncAll and PinInfo
+		// consumers can see and eventually reconcile it, rather than
+		// discarding the failure right away. op.Cancel() only lets
+		// this operation's watcher goroutine in dispatch() exit; it
+		// does not affect the reported status.
+		op.SetError(err)
+		op.Cancel()
+		return
+	}
+
+	op.SetPhase(optracker.PhaseDone)
+	op.Cancel()
+	spt.optracker.Clean(op)
+}
+
+// scheduleRetry arranges for op to be re-dispatched after an
+// exponentially growing delay, unless Config.PinRetryMaxAttempts has
+// already been reached, in which case it does nothing and returns
+// false. The wait is interrupted, without running the retry, if op is
+// cancelled in the meantime (Untrack, or a new Track/Untrack replacing
+// it).
+func (spt *Tracker) scheduleRetry(op *optracker.Operation, opErr error) bool {
+	if op.AttemptCount() >= spt.config.PinRetryMaxAttempts {
+		return false
+	}
+
+	delay := backoffDelay(spt.config, op.AttemptCount())
+	op.SetError(opErr)
+	op.SetNextRetry(time.Now().Add(delay))
+
+	spt.wg.Add(1)
+	go func() {
+		defer spt.wg.Done()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-op.Context().Done(): // cancelled while waiting for the retry
+			spt.optracker.Clean(op)
+			return
+		case <-timer.C:
+		}
+
+		op.SetNextRetry(time.Time{})
+		op.SetPhase(optracker.PhaseQueued)
+		spt.enqueue(op)
+	}()
+	return true
+}
+
+// backoffDelay computes the delay before the next retry, given how many
+// attempts have already been made, following cfg.PinRetryInitialDelay,
+// cfg.PinRetryMultiplier and cfg.PinRetryMaxDelay.
+func backoffDelay(cfg *Config, attempts int) time.Duration {
+	delay := float64(cfg.PinRetryInitialDelay)
+	for i := 1; i < attempts; i++ {
+		delay *= cfg.PinRetryMultiplier
+	}
+	d := time.Duration(delay)
+	if max := cfg.PinRetryMaxDelay; max > 0 && d > max {
+		d = max
+	}
+	return d
+}
+
+func (spt *Tracker) pin(op *optracker.Operation) error {
+	pin := api.Pin{Cid: op.Cid()}
+	return spt.rpcClient.CallContext(
+		op.Context(),
+		"",
+		"Cluster",
+		"IPFSPin",
+		pin.ToSerial(),
+		&struct{}{},
+	)
+}
+
+func (spt *Tracker) unpin(op *optracker.Operation) error {
+	pin := api.Pin{Cid: op.Cid()}
+	return spt.rpcClient.CallContext(
+		op.Context(),
+		"",
+		"Cluster",
+		"IPFSUnpin",
+		pin.ToSerial(),
+		&struct{}{},
+	)
+}
+
+// opQueue is a container/heap.Interface ordering operations by
+// descending Priority, falling back to arrival order (FIFO) between
+// operations sharing the same Priority.
+type opQueue []*optracker.Operation
+
+func (q opQueue) Len() int { return len(q) }
+
+func (q opQueue) Less(i, j int) bool {
+	if q[i].Priority() != q[j].Priority() {
+		return q[i].Priority() > q[j].Priority()
+	}
+	return q[i].Seq() < q[j].Seq()
+}
+
+func (q opQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *opQueue) Push(x interface{}) {
+	*q = append(*q, x.(*optracker.Operation))
+}
+
+func (q *opQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	op := old[n-1]
+	*q = old[:n-1]
+	return op
+}
@@ -77,9 +77,17 @@ type mockService struct {
 }
 
 func mockRPCClient(t *testing.T) *rpc.Client {
+	return newMockRPCClient(t, &mockService{})
+}
+
+// newMockRPCClient wires up an in-process RPC client/server pair serving
+// svc under the "Cluster" name, the convention every mock service in
+// this package's tests relies on, so that each test file only needs to
+// supply its own mock service type.
+func newMockRPCClient(t *testing.T, svc interface{}) *rpc.Client {
 	s := rpc.NewServer(nil, "mock")
 	c := rpc.NewClientWithServer(nil, "mock", s)
-	err := s.RegisterName("Cluster", &mockService{})
+	err := s.RegisterName("Cluster", svc)
 	if err != nil {
 		t.Fatal(err)
 	}